@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/klyngen/flightlogger/common"
+	"github.com/klyngen/flightlogger/storage/errs"
+	"github.com/pkg/errors"
+)
+
+// DbAuditLog - an immutable record of a single Create/Update/Delete operation
+// performed against one of the tracked entities. Rows are never updated or
+// deleted, they are only ever appended to by writeAuditEntry.
+type DbAuditLog struct {
+	ID         uint `gorm:"primary_key"`
+	CreatedAt  time.Time
+	ActorID    uint   `gorm:"index"`
+	EntityType string `gorm:"index"`
+	EntityID   uint   `gorm:"index"`
+	Operation  string
+	Diff       string `gorm:"type:text"`
+}
+
+// auditOperation enumerates the operations that get written to DbAuditLog
+type auditOperation string
+
+const (
+	auditOperationCreate auditOperation = "CREATE"
+	auditOperationUpdate auditOperation = "UPDATE"
+	auditOperationDelete auditOperation = "DELETE"
+)
+
+// writeAuditEntry records a single operation against an entity in the same
+// transaction as the change itself. The before/after values are marshalled to
+// JSON and stored verbatim as the diff so that GetEntityHistory can replay
+// what changed without needing per-entity diffing logic.
+func (d *OrmDatabase) writeAuditEntry(actorID uint, entityType string, entityID uint, operation auditOperation, before interface{}, after interface{}) error {
+	diff, err := json.Marshal(struct {
+		Before interface{} `json:"before,omitempty"`
+		After  interface{} `json:"after,omitempty"`
+	}{Before: before, After: after})
+
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal audit diff")
+	}
+
+	entry := DbAuditLog{
+		ActorID:    actorID,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Operation:  string(operation),
+		Diff:       string(diff),
+	}
+
+	return errs.Classify(d.db.Create(&entry).Error)
+}
+
+// GetEntityHistory - returns the full, ordered audit trail for a single
+// entity. entityType must match the type used when the entry was written;
+// currently only "user" and "location" entries are written.
+func (d *OrmDatabase) GetEntityHistory(entityType string, id uint) ([]common.AuditEntry, error) {
+	var logs []DbAuditLog
+
+	err := d.db.Where("entity_type = ? AND entity_id = ?", entityType, id).Order("created_at asc").Find(&logs).Error
+
+	if err != nil {
+		return nil, errs.Classify(err)
+	}
+
+	return demapAuditEntries(logs), nil
+}
+
+// RestoreEntity - brings a soft-deleted row back by clearing its deleted_at
+// column. Only entities that are soft-deleted (currently Locations) can be
+// restored this way; hard-deleted entities have no row left to restore. Runs
+// inside a transaction and writes an audit entry like every other write path
+// in this package, so a restore shows up in GetEntityHistory too.
+func (d *OrmDatabase) RestoreEntity(actorID uint, entityType string, id uint) error {
+	switch entityType {
+	case "location":
+		return d.WithTx(func(tx Database) error {
+			ormTx := tx.(*OrmDatabase)
+
+			var before DbLocation
+			if err := ormTx.db.Unscoped().First(&before, id).Error; err != nil {
+				return errs.Classify(err)
+			}
+
+			if err := ormTx.db.Unscoped().Model(&DbLocation{}).Where("id = ?", id).Update("deleted_at", nil).Error; err != nil {
+				return errs.Classify(err)
+			}
+
+			var after DbLocation
+			if err := ormTx.db.First(&after, id).Error; err != nil {
+				return errs.Classify(err)
+			}
+
+			return ormTx.writeAuditEntry(actorID, entityType, id, auditOperationUpdate, before, after)
+		})
+	default:
+		return errors.Errorf("RestoreEntity does not support entities of type %q", entityType)
+	}
+}
+
+func demapAuditEntries(logs []DbAuditLog) []common.AuditEntry {
+	entries := make([]common.AuditEntry, len(logs))
+
+	for i, l := range logs {
+		entries[i] = common.AuditEntry{
+			ActorID:    l.ActorID,
+			EntityType: l.EntityType,
+			EntityID:   l.EntityID,
+			Operation:  string(l.Operation),
+			Diff:       l.Diff,
+			Timestamp:  l.CreatedAt,
+		}
+	}
+
+	return entries
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/klyngen/flightlogger/storage/migrations"
+)
+
+func TestRestoreEntityLocationWritesAuditEntry(t *testing.T) {
+	db := newTestOrmDatabase(t)
+
+	if err := migrations.MigrateUp(db.db, db.dialect, 0); err != nil {
+		t.Fatalf("unable to run migrations: %v", err)
+	}
+
+	if err := db.db.Exec("INSERT INTO db_locations (id, name, deleted_at) VALUES (1, 'Test Site', CURRENT_TIMESTAMP)").Error; err != nil {
+		t.Fatalf("unable to seed a soft-deleted location: %v", err)
+	}
+
+	if err := db.RestoreEntity(7, "location", 1); err != nil {
+		t.Fatalf("RestoreEntity failed: %v", err)
+	}
+
+	var deletedAt sql.NullString
+	if err := db.db.Raw("SELECT deleted_at FROM db_locations WHERE id = ?", 1).Row().Scan(&deletedAt); err != nil {
+		t.Fatalf("unable to read back deleted_at: %v", err)
+	}
+
+	if deletedAt.Valid {
+		t.Fatalf("expected deleted_at to be cleared, still %q", deletedAt.String)
+	}
+
+	var auditCount int
+	if err := db.db.Raw("SELECT COUNT(*) FROM db_audit_logs WHERE entity_type = ? AND entity_id = ? AND operation = ? AND actor_id = ?",
+		"location", 1, "UPDATE", 7).Row().Scan(&auditCount); err != nil {
+		t.Fatalf("unable to count audit entries: %v", err)
+	}
+
+	if auditCount != 1 {
+		t.Fatalf("expected exactly 1 audit entry for the restore, got %d", auditCount)
+	}
+}
@@ -6,109 +6,113 @@ import (
 	"strings"
 
 	"github.com/jinzhu/gorm"
-	// This import is needed in order to utilize MySql
+	// These imports are needed in order to utilize their respective drivers
 	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite3"
 	"github.com/klyngen/flightlogger/common"
+	"github.com/klyngen/flightlogger/storage/dialects"
+	"github.com/klyngen/flightlogger/storage/errs"
+	"github.com/klyngen/flightlogger/storage/migrations"
 	"github.com/pkg/errors"
 )
 
 // OrmDatabase - should implement the databaseInterface
 type OrmDatabase struct {
-	db *gorm.DB
-}
-
-// MigrateDatabase - migrates the database
+	db      *gorm.DB
+	dialect dialects.Dialect
+	// inTx - set on the OrmDatabase handed to a WithTx callback, so a nested
+	// WithTx call (composing CreateUser/CreateLocation/etc. from within an
+	// outer one) reuses the open transaction instead of trying to Begin() a
+	// second one.
+	inTx bool
+}
+
+// DialectConfig - identifies which SQL backend OrmDatabase should connect to.
+// Driver is a gorm dialect name ("mysql", "postgres" or "sqlite3") and DSN is
+// passed straight through to gorm.Open.
+type DialectConfig struct {
+	Driver string
+	DSN    string
+}
+
+// MigrateDatabase - brings the database up to the newest migration known to
+// this binary. See storage/migrations for the ordered list of steps; this
+// used to be a hand-ordered AutoMigrate chain where a failed AddForeignKey
+// call would be silently overwritten by the next statement's err.
 func (d *OrmDatabase) MigrateDatabase() error {
-	// Migrate location first
-
-	err := d.db.AutoMigrate(&DbCountryPart{}).Error
-	err = d.db.AutoMigrate(&DbFileReference{}).Error
-	err = d.db.AutoMigrate(&DbCoordinates{}).Error
-	err = d.db.AutoMigrate(&DbLocation{}).Error
-
-	if err != nil {
-		return errors.Wrap(err, "Unable to migrate basic Location-coordinates")
-	}
-
-	// Create club entity before user and flights
-	err = d.db.AutoMigrate(&DbClub{}).Error
-
-	// Waypoint and start are dependent on location
-	err = d.db.AutoMigrate(&DbWaypoint{}).Error
-	err = d.db.AutoMigrate(&DbStartSite{}).Error
-
-	if err != nil {
-		return errors.Wrap(err, "Unable to migrate flight base-entities")
-	}
-
-	// Wing related data
-	err = d.db.AutoMigrate(&DbWingScoreDetails{}).Error
-	err = d.db.AutoMigrate(&DbWing{}).Error
+	return errors.Wrap(migrations.MigrateUp(d.db, d.dialect, 0), "Unable to migrate the database")
+}
 
-	if err != nil {
-		return errors.Wrap(err, "Unable to migrate wing-entities")
-	}
+// MigrateUp - applies every pending migration up to and including target.
+// Pass 0 to apply everything that is currently pending.
+func (d *OrmDatabase) MigrateUp(target int) error {
+	return errors.Wrap(migrations.MigrateUp(d.db, d.dialect, target), "Unable to migrate the database up")
+}
 
-	// Flight related entities
-	err = d.db.AutoMigrate(&DbFlightType{}).Error
-	err = d.db.AutoMigrate(&DbTakeoffType{}).Error
-	err = d.db.AutoMigrate(&DbIncident{}).Error
-	err = d.db.AutoMigrate(&DbFlight{}).Error
+// MigrateDown - rolls back every applied migration newer than target.
+func (d *OrmDatabase) MigrateDown(target int) error {
+	return errors.Wrap(migrations.MigrateDown(d.db, d.dialect, target), "Unable to migrate the database down")
+}
 
-	if err != nil {
-		return errors.Wrap(err, "Unable to migrate flight-entities")
-	}
+// Status - reports which migrations have been applied and which are pending
+func (d *OrmDatabase) Status() ([]migrations.AppliedStatus, error) {
+	return migrations.Status(d.db)
+}
 
-	// Set up the user related entities
-	err = d.db.AutoMigrate(&DbCredentials{}).Error
-	err = d.db.AutoMigrate(&DbUserScope{}).Error
-	err = d.db.AutoMigrate(&DbUserGroup{}).Error
-	err = d.db.AutoMigrate(&DbUser{}).Error
-	if err != nil {
-		return errors.Wrap(err, "Unable to migrate user-entities")
-	}
+// CreateConnection - establish a connection to a MySQL database
+func (d *OrmDatabase) CreateConnection(username string, password string, database string, port string, hostname string) error {
+	return d.CreateConnectionWithDriver("mysql", fmt.Sprintf("%s:%s@/%s?charset=utf8&parseTime=True&loc=Local", username, password, database))
+}
 
-	err = d.db.Model(&DbCredentials{}).AddForeignKey("user_id", "db_users(id)", "CASCADE", "CASCADE").Error
-	err = d.db.Model(&DbLocation{}).AddForeignKey("countrypart_referer", "db_countryparts(id)", "SET NULL", "SET NULL").Error
-	err = d.db.Model(&DbLocation{}).AddForeignKey("coordinates_referer", "db_coordinates(id)", "SET NULL", "SET NULL").Error
+// CreateConnectionWithDriver - establishes a connection using an explicit
+// driver and DSN. Supports "mysql", "postgres" and "sqlite3" - the latter is
+// handy for tests and small deployments that don't want a separate database
+// server, e.g. CreateConnectionWithDriver("sqlite3", ":memory:").
+func (d *OrmDatabase) CreateConnectionWithDriver(driver string, dsn string) error {
+	dialect, err := dialects.For(driver)
 
 	if err != nil {
-		return errors.Wrap(err, "Unable to establich foreign keys")
+		return err
 	}
-	return errors.Wrap(err, "Unable to migrate the database")
-}
 
-// CreateConnection - establish a connection to the database
-func (d *OrmDatabase) CreateConnection(username string, password string, database string, port string, hostname string) error {
-	db, err := gorm.Open("mysql", fmt.Sprintf("%s:%s@/%s?charset=utf8&parseTime=True&loc=Local", username, password, database))
+	db, err := gorm.Open(driver, dsn)
 
 	if err != nil {
 		return err
 	}
 
 	d.db = db
+	d.dialect = dialect
 	return nil
 }
 
 // ############## USER RELATED QUERIES ############################
 
-// CreateUser - try to create a new user
-func (d *OrmDatabase) CreateUser(user common.User) (common.User, error) {
+// CreateUser - try to create a new user. Runs inside a transaction so a
+// failure creating the credentials doesn't leave an orphaned user behind.
+func (d *OrmDatabase) CreateUser(actorID uint, user common.User) (common.User, error) {
 	mappedUser, mappedCreds := mapUser(user)
-	err := d.db.Create(&mappedUser).Error
 
-	// Create the base user entity
-	if err != nil {
-		return user, err
-	}
+	err := d.WithTx(func(tx Database) error {
+		ormTx := tx.(*OrmDatabase)
 
-	// Set the user ID of the Credentials
-	mappedCreds.UserID = mappedUser.ID
+		if err := ormTx.db.Create(&mappedUser).Error; err != nil {
+			return errs.Classify(err)
+		}
 
-	err = d.db.Create(&mappedCreds).Error
+		// Set the user ID of the Credentials
+		mappedCreds.UserID = mappedUser.ID
 
-	if err != nil {
+		if err := ormTx.db.Create(&mappedCreds).Error; err != nil {
+			return errs.Classify(err)
+		}
+
+		return ormTx.writeAuditEntry(actorID, "user", mappedUser.ID, auditOperationCreate, nil, mappedUser)
+	})
 
+	if err != nil {
+		return user, err
 	}
 
 	return demapUser(mappedUser), nil
@@ -117,14 +121,14 @@ func (d *OrmDatabase) CreateUser(user common.User) (common.User, error) {
 // GetAllUsers - gets all users
 func (d *OrmDatabase) GetAllUsers(limit int, page int) ([]common.User, error) {
 	var users []DbUser
-	d.db.Limit(limit).Offset((page - 1) * limit).Find(&users)
-	return demapUsers(users), nil
+	err := d.db.Limit(limit).Offset((page - 1) * limit).Find(&users).Error
+	return demapUsers(users), errs.Classify(err)
 }
 
 // GetUser - gets a single user if it exists
 func (d *OrmDatabase) GetUser(ID uint) (common.User, error) {
 	var user DbUser
-	err := errors.Wrap(d.db.First(&user, ID).Error, "Unable to get user")
+	err := errs.Classify(d.db.First(&user, ID).Error)
 
 	user.ID = ID
 
@@ -132,96 +136,114 @@ func (d *OrmDatabase) GetUser(ID uint) (common.User, error) {
 }
 
 // UpdateUser - update an existing user if it exists
-func (d *OrmDatabase) UpdateUser(ID uint, user common.User) (common.User, error) {
-
+func (d *OrmDatabase) UpdateUser(actorID uint, ID uint, user common.User) (common.User, error) {
 	dbUser, _ := mapUser(user)
 	dbUser.ID = ID
 
-	// If the user has set its salt and hash, we probably want to update the credentials
-	if user.PasswordSalt != nil && user.PasswordHash != nil {
-		var creds DbCredentials
-		err := d.db.Where("user_id = ?", ID).First(&creds).Error
+	err := d.WithTx(func(tx Database) error {
+		ormTx := tx.(*OrmDatabase)
 
-		if err != nil {
-			return user, errors.Wrap(err, "Unable to update password details")
+		var existingUser DbUser
+
+		if err := ormTx.db.First(&existingUser, ID).Error; err != nil {
+			return errs.Classify(err)
 		}
 
-		// Set the password
-		creds.PasswordHash = user.PasswordHash
-		creds.PasswordSalt = user.PasswordSalt
+		// If the user has set its salt and hash, we probably want to update the credentials
+		if user.PasswordSalt != nil && user.PasswordHash != nil {
+			var creds DbCredentials
+			if err := ormTx.db.Where("user_id = ?", ID).First(&creds).Error; err != nil {
+				return errs.Classify(err)
+			}
 
-		err = d.db.Save(&creds).Error
+			// Set the password
+			creds.PasswordHash = user.PasswordHash
+			creds.PasswordSalt = user.PasswordSalt
 
-		if err != nil {
-			return user, errors.Wrap(err, "Unable to update password details")
+			if err := ormTx.db.Save(&creds).Error; err != nil {
+				return errs.Classify(err)
+			}
 		}
-	}
-
-	return demapUser(dbUser), errors.Wrap(d.db.Save(&dbUser).Error, "Unable to update a user")
-}
 
-// DeleteUser - deletes a user
-// this deletion uses a hard deletes and removes all data related to a user
-func (d *OrmDatabase) DeleteUser(ID uint) error {
-	var user DbUser
+		if err := ormTx.db.Save(&dbUser).Error; err != nil {
+			return errs.Classify(err)
+		}
 
-	err := d.db.First(&user, ID).Error
+		return ormTx.writeAuditEntry(actorID, "user", ID, auditOperationUpdate, existingUser, dbUser)
+	})
 
 	if err != nil {
-		return errors.Wrap(err, "Cannot delete a user we cannot find")
+		return user, err
 	}
 
-	err = d.db.Model(&user).Association("Wings").Clear().Error
+	return demapUser(dbUser), nil
+}
 
-	if err != nil {
-		errors.Wrap(err, "Unable to remove associated wings")
-	}
+// DeleteUser - deletes a user
+// this deletion uses a hard deletes and removes all data related to a user.
+// Runs inside a transaction so a failure partway through doesn't leave the
+// user deleted but its associations (or the audit entry) intact, or vice versa.
+func (d *OrmDatabase) DeleteUser(actorID uint, ID uint) error {
+	return d.WithTx(func(tx Database) error {
+		ormTx := tx.(*OrmDatabase)
 
-	err = d.db.Model(&user).Association("Groups").Error
+		var user DbUser
 
-	if err != nil {
-		errors.Wrap(err, "Unable to remove associated groups")
-	}
+		if err := ormTx.db.First(&user, ID).Error; err != nil {
+			return errs.Classify(err)
+		}
 
-	err = d.db.Model(&user).Association("Scopes").Error
+		if err := ormTx.db.Model(&user).Association("Wings").Clear().Error; err != nil {
+			return errors.Wrap(err, "Unable to remove associated wings")
+		}
 
-	if err != nil {
-		errors.Wrap(err, "Unable to remove associated scopes")
-	}
+		if err := ormTx.db.Model(&user).Association("Groups").Clear().Error; err != nil {
+			return errors.Wrap(err, "Unable to remove associated groups")
+		}
 
-	// Hard delete the user
-	err = d.db.Unscoped().Delete(&user, ID).Error
+		if err := ormTx.db.Model(&user).Association("Scopes").Clear().Error; err != nil {
+			return errors.Wrap(err, "Unable to remove associated scopes")
+		}
 
-	if err != nil {
-		return errors.Wrap(err, "Unable to delete the user")
-	}
+		// Hard delete the user
+		if err := ormTx.db.Unscoped().Delete(&user, ID).Error; err != nil {
+			return errs.Classify(err)
+		}
 
-	return nil
+		return ormTx.writeAuditEntry(actorID, "user", ID, auditOperationDelete, user, nil)
+	})
 }
 
 // CreateLocation - creates a location. Locations are then again used
-// by StartSite, Waypoint etc
-func (d *OrmDatabase) CreateLocation(location common.Location) (common.Location, error) {
+// by StartSite, Waypoint etc. Runs inside a transaction so a failure storing
+// the location itself doesn't leave an orphaned coordinates/countrypart row behind.
+func (d *OrmDatabase) CreateLocation(actorID uint, location common.Location) (common.Location, error) {
 	mappedLocation := mapLocation(location)
 
-	// Store the coordinates first
-	err := d.db.Create(&mappedLocation.Coordinates).Error
+	err := d.WithTx(func(tx Database) error {
+		ormTx := tx.(*OrmDatabase)
 
-	if err != nil {
-		return location, errors.Wrap(err, "Unable to store coordinates")
-	}
+		// Store the coordinates first
+		if err := ormTx.db.Create(&mappedLocation.Coordinates).Error; err != nil {
+			return errs.Classify(err)
+		}
+
+		partID := ormTx.resolveCountryPart(mappedLocation.CountryPart)
 
-	partID := d.resolveCountryPart(mappedLocation.CountryPart)
+		// Make it possible to resolve the foreign key later
+		mappedLocation.CoordinatesReferer = mappedLocation.Coordinates.ID
+		mappedLocation.CountrypartReferer = partID
+		// Then store the countrypart, if it is not empty
 
-	// Make it possible to resolve the foreign key later
-	mappedLocation.CoordinatesReferer = mappedLocation.Coordinates.ID
-	mappedLocation.CountrypartReferer = partID
-	// Then store the countrypart, if it is not empty
+		if err := ormTx.db.Create(&mappedLocation).Error; err != nil {
+			return errs.Classify(err)
+		}
 
-	err = d.db.Create(&mappedLocation).Error
+		return ormTx.writeAuditEntry(actorID, "location", mappedLocation.ID, auditOperationCreate, nil, mappedLocation)
+	})
 
 	if err != nil {
-		return location, errors.Wrap(err, "Could not create the location")
+		return location, err
 	}
 
 	return demapLocation(mappedLocation), nil
@@ -264,55 +286,76 @@ func (d *OrmDatabase) getCountryPart(part DbCountryPart) uint {
 }
 
 // UpdateLocation updates the location and if needed its CountryPart and coordinates
-func (d *OrmDatabase) UpdateLocation(ID uint, location common.Location) (common.Location, error) {
+func (d *OrmDatabase) UpdateLocation(actorID uint, ID uint, location common.Location) (common.Location, error) {
 	var existingLocation DbLocation
 
-	d.db.First(&existingLocation, ID)
+	err := d.WithTx(func(tx Database) error {
+		ormTx := tx.(*OrmDatabase)
 
-	newCountryPart := DbCountryPart{
-		AreaName:    location.AreaName,
-		PostalCode:  location.PostalCode,
-		CountryPart: location.CountryPart,
-	}
+		if err := ormTx.db.First(&existingLocation, ID).Error; err != nil {
+			return errs.Classify(err)
+		}
 
-	// resolve the country part
-	partID := d.resolveCountryPart(newCountryPart)
+		before := existingLocation
 
-	var coordinates DbCoordinates
+		newCountryPart := DbCountryPart{
+			AreaName:    location.AreaName,
+			PostalCode:  location.PostalCode,
+			CountryPart: location.CountryPart,
+		}
 
-	// set the coordinates for the location
-	err := d.db.Model(&existingLocation).Related(&coordinates, "Coordinates").Error
+		// resolve the country part
+		partID := ormTx.resolveCountryPart(newCountryPart)
+
+		var coordinates DbCoordinates
+
+		// set the coordinates for the location
+		if err := ormTx.db.Model(&existingLocation).Related(&coordinates, "Coordinates").Error; err != nil {
+			log.Printf("Unable to find the coordinates: %v", err)
+			return errs.Classify(err)
+		}
 
-	if err != nil { // The coordinates could not be found
-		log.Printf("Unable to find the coordinates: %v", err)
-		return location, err
-	} else {
 		coordinates.Longitude = existingLocation.Coordinates.Longitude
 		coordinates.Lattitude = existingLocation.Coordinates.Lattitude
-	}
 
-	// A countrypart can change. The coordinates object will never be replaced once it exists
-	existingLocation.CountrypartReferer = partID
+		// A countrypart can change. The coordinates object will never be replaced once it exists
+		existingLocation.CountrypartReferer = partID
+
+		if err := ormTx.db.Save(&existingLocation).Error; err != nil {
+			return errs.Classify(err)
+		}
+
+		return ormTx.writeAuditEntry(actorID, "location", ID, auditOperationUpdate, before, existingLocation)
+	})
+
+	if err != nil {
+		return location, err
+	}
 
-	return demapLocation(existingLocation), errors.Wrap(d.db.Save(&existingLocation).Error, "Unable to update a user")
+	return demapLocation(existingLocation), nil
 }
 
 // DeleteLocation - softDeletes a location
-func (d *OrmDatabase) DeleteLocation(ID uint) error {
+func (d *OrmDatabase) DeleteLocation(actorID uint, ID uint) error {
+	return d.WithTx(func(tx Database) error {
+		ormTx := tx.(*OrmDatabase)
 
-	var loc DbLocation
+		var loc DbLocation
 
-	err := errors.Wrap(d.db.First(&loc, ID).Error, "Unable to get location")
+		if err := ormTx.db.First(&loc, ID).Error; err != nil {
+			return errs.Classify(err)
+		}
 
-	log.Println(loc)
+		log.Println(loc)
 
-	loc.ID = ID
+		loc.ID = ID
 
-	if err != nil {
-		return errors.Wrap(err, "Cannot delete a user we cannot find")
-	}
+		if err := ormTx.db.Delete(&loc).Error; err != nil {
+			return errs.Classify(err)
+		}
 
-	return d.db.Delete(&loc).Error
+		return ormTx.writeAuditEntry(actorID, "location", ID, auditOperationDelete, loc, nil)
+	})
 }
 
 // LocationSearchByName finds relevant locations based on user input
@@ -323,7 +366,7 @@ func (d *OrmDatabase) LocationSearchByName(name string) ([]common.Location, erro
 	err := d.db.Where("name Like ?", strings.ToLower(name)+"%").Find(&locations).Error
 
 	if err != nil {
-		return nil, errors.Wrap(err, "Unable to find locations")
+		return nil, errs.Classify(err)
 	}
 
 	return demapLocations(locations), nil
@@ -333,7 +376,7 @@ func (d *OrmDatabase) LocationSearchByName(name string) ([]common.Location, erro
 func (d *OrmDatabase) GetLocation(ID uint) (common.Location, error) {
 	var loc DbLocation
 
-	err := errors.Wrap(d.db.First(&loc, ID).Error, "Unable to get location")
+	err := errs.Classify(d.db.First(&loc, ID).Error)
 
 	if err != nil {
 		return demapLocation(loc), err
@@ -0,0 +1,17 @@
+package storage
+
+import "testing"
+
+// TestGetAllUsersClassifiesError guards against GetAllUsers discarding the
+// query's error and always reporting success, as it used to.
+func TestGetAllUsersClassifiesError(t *testing.T) {
+	db := newTestOrmDatabase(t)
+
+	if err := db.db.Close(); err != nil {
+		t.Fatalf("unable to close the test connection: %v", err)
+	}
+
+	if _, err := db.GetAllUsers(10, 1); err == nil {
+		t.Fatal("expected GetAllUsers to return an error once its connection is closed, got nil")
+	}
+}
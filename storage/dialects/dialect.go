@@ -0,0 +1,30 @@
+// Package dialects captures the handful of behaviours that differ between the
+// SQL backends OrmDatabase can run against, so the rest of the storage
+// package can stay written against a single driver-agnostic interface.
+package dialects
+
+import "github.com/pkg/errors"
+
+// Dialect - driver-specific quirks needed by OrmDatabase and its migrations
+type Dialect interface {
+	// Name - the gorm/sql driver name this dialect was built for
+	Name() string
+	// SupportsForeignKeys - whether ADD CONSTRAINT ... FOREIGN KEY is
+	// supported by this driver. SQLite ignores foreign keys by default, so
+	// callers must treat AddForeignKey as a no-op there rather than erroring.
+	SupportsForeignKeys() bool
+}
+
+// For - looks up the Dialect for a gorm driver name
+func For(driver string) (Dialect, error) {
+	switch driver {
+	case "mysql":
+		return mysqlDialect{}, nil
+	case "postgres":
+		return postgresDialect{}, nil
+	case "sqlite3":
+		return sqlite3Dialect{}, nil
+	default:
+		return nil, errors.Errorf("unsupported database driver %q", driver)
+	}
+}
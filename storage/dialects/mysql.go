@@ -0,0 +1,11 @@
+package dialects
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string {
+	return "mysql"
+}
+
+func (mysqlDialect) SupportsForeignKeys() bool {
+	return true
+}
@@ -0,0 +1,11 @@
+package dialects
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return "postgres"
+}
+
+func (postgresDialect) SupportsForeignKeys() bool {
+	return true
+}
@@ -0,0 +1,14 @@
+package dialects
+
+type sqlite3Dialect struct{}
+
+func (sqlite3Dialect) Name() string {
+	return "sqlite3"
+}
+
+// SupportsForeignKeys - false: SQLite enforces foreign keys only if PRAGMA
+// foreign_keys is turned on per-connection, so AddForeignKey is a no-op here
+// rather than a failure. Good enough for the in-memory databases tests use.
+func (sqlite3Dialect) SupportsForeignKeys() bool {
+	return false
+}
@@ -0,0 +1,70 @@
+// Package errs classifies the raw errors that come back from the database
+// driver into a small set of sentinel errors, so that callers (API handlers
+// in particular) can tell "already exists" from "not found" with
+// errors.Is instead of matching on driver-specific error strings.
+package errs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// Sentinel errors. Compare against these with errors.Is, never by string match.
+var (
+	// ErrNotFound - no row matched the query
+	ErrNotFound = errors.New("entity not found")
+	// ErrDuplicate - a unique constraint was violated (e.g. duplicate email)
+	ErrDuplicate = errors.New("entity already exists")
+	// ErrForeignKey - a foreign key constraint was violated
+	ErrForeignKey = errors.New("foreign key constraint violated")
+	// ErrConflict - the write could not be applied because of a conflicting state
+	ErrConflict = errors.New("conflicting write")
+
+	mysqlDuplicateEntry  uint16 = 1062
+	mysqlForeignKeyError uint16 = 1452
+
+	postgresUniqueViolation     pq.ErrorCode = "23505"
+	postgresForeignKeyViolation pq.ErrorCode = "23503"
+)
+
+// Classify - inspects err (as returned by gorm/the underlying SQL driver) and
+// maps it to one of the sentinel errors above where possible. The original
+// error text is preserved so the message remains useful for logging; callers
+// that need to branch on the failure kind should use errors.Is(err, errs.X)
+// rather than inspecting the message. Errors that don't match a known case
+// are returned unchanged.
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if gorm.IsRecordNotFoundError(err) {
+		return fmt.Errorf("%w: %s", ErrNotFound, err)
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlDuplicateEntry:
+			return fmt.Errorf("%w: %s", ErrDuplicate, err)
+		case mysqlForeignKeyError:
+			return fmt.Errorf("%w: %s", ErrForeignKey, err)
+		}
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case postgresUniqueViolation:
+			return fmt.Errorf("%w: %s", ErrDuplicate, err)
+		case postgresForeignKeyViolation:
+			return fmt.Errorf("%w: %s", ErrForeignKey, err)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,62 @@
+package errs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+func TestClassifyRecordNotFound(t *testing.T) {
+	if got := Classify(gorm.ErrRecordNotFound); !errors.Is(got, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", got)
+	}
+}
+
+func TestClassifyMySQLDuplicateEntry(t *testing.T) {
+	err := &mysql.MySQLError{Number: mysqlDuplicateEntry, Message: "Duplicate entry"}
+
+	if got := Classify(err); !errors.Is(got, ErrDuplicate) {
+		t.Fatalf("expected ErrDuplicate, got %v", got)
+	}
+}
+
+func TestClassifyMySQLForeignKeyError(t *testing.T) {
+	err := &mysql.MySQLError{Number: mysqlForeignKeyError, Message: "a foreign key constraint fails"}
+
+	if got := Classify(err); !errors.Is(got, ErrForeignKey) {
+		t.Fatalf("expected ErrForeignKey, got %v", got)
+	}
+}
+
+func TestClassifyPostgresUniqueViolation(t *testing.T) {
+	err := &pq.Error{Code: postgresUniqueViolation, Message: "duplicate key value violates unique constraint"}
+
+	if got := Classify(err); !errors.Is(got, ErrDuplicate) {
+		t.Fatalf("expected ErrDuplicate, got %v", got)
+	}
+}
+
+func TestClassifyPostgresForeignKeyViolation(t *testing.T) {
+	err := &pq.Error{Code: postgresForeignKeyViolation, Message: "violates foreign key constraint"}
+
+	if got := Classify(err); !errors.Is(got, ErrForeignKey) {
+		t.Fatalf("expected ErrForeignKey, got %v", got)
+	}
+}
+
+func TestClassifyPassesThroughUnknownErrors(t *testing.T) {
+	original := errors.New("connection refused")
+
+	if got := Classify(original); !errors.Is(got, original) {
+		t.Fatalf("expected the original error to pass through unchanged, got %v", got)
+	}
+}
+
+func TestClassifyNil(t *testing.T) {
+	if got := Classify(nil); got != nil {
+		t.Fatalf("expected nil, got %v", got)
+	}
+}
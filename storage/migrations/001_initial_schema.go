@@ -0,0 +1,146 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/klyngen/flightlogger/storage/dialects"
+)
+
+// migration001InitialSchema re-creates the tables that used to be produced by
+// the hand-ordered AutoMigrate chain in MigrateDatabase. It intentionally
+// does not reference the live Db* structs in package storage - migrations
+// must stay correct for databases created with older versions of those
+// structs, so each one carries its own column list. Column types that differ
+// between mysql/postgres/sqlite3 (the id/datetime/binary columns) are
+// resolved through the dialect so this migration can actually run against
+// all three, not just mysql.
+var migration001InitialSchema = Migration{
+	ID:          1,
+	Description: "create the base location, user, wing and flight tables",
+	Up: func(db *gorm.DB, dialect dialects.Dialect) error {
+		id := idColumn(dialect)
+		datetime := datetimeColumn(dialect)
+		binary := binaryColumn(dialect)
+
+		statements := []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_country_parts (
+				%s,
+				area_name VARCHAR(255),
+				postal_code VARCHAR(255),
+				country_part VARCHAR(255)
+			)`, id),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_file_references (
+				%s,
+				created_at %s,
+				path VARCHAR(255)
+			)`, id, datetime),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_coordinates (
+				%s,
+				lattitude DOUBLE PRECISION,
+				longitude DOUBLE PRECISION
+			)`, id),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_locations (
+				%s,
+				created_at %s,
+				updated_at %s,
+				deleted_at %s,
+				name VARCHAR(255),
+				coordinates_referer INTEGER,
+				countrypart_referer INTEGER
+			)`, id, datetime, datetime, datetime),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_clubs (
+				%s,
+				created_at %s,
+				name VARCHAR(255)
+			)`, id, datetime),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_start_sites (
+				%s,
+				created_at %s,
+				name VARCHAR(255),
+				location_referer INTEGER,
+				difficulty_level INTEGER
+			)`, id, datetime),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_wing_score_details (
+				%s,
+				score INTEGER
+			)`, id),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_wings (
+				%s,
+				created_at %s,
+				name VARCHAR(255),
+				user_id INTEGER,
+				score_details_referer INTEGER
+			)`, id, datetime),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_flight_types (
+				%s,
+				name VARCHAR(255)
+			)`, id),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_takeoff_types (
+				%s,
+				name VARCHAR(255)
+			)`, id),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_incidents (
+				%s,
+				created_at %s,
+				flight_id INTEGER,
+				error_level INTEGER,
+				description VARCHAR(1024)
+			)`, id, datetime),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_flights (
+				%s,
+				created_at %s,
+				user_id INTEGER,
+				start_site_referer INTEGER,
+				flight_type_referer INTEGER,
+				takeoff_type_referer INTEGER
+			)`, id, datetime),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_credentials (
+				%s,
+				user_id INTEGER,
+				password_hash %s,
+				password_salt %s
+			)`, id, binary, binary),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_user_scopes (
+				%s,
+				name VARCHAR(255)
+			)`, id),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_user_groups (
+				%s,
+				name VARCHAR(255)
+			)`, id),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_users (
+				%s,
+				created_at %s,
+				updated_at %s,
+				email VARCHAR(255),
+				first_name VARCHAR(255),
+				last_name VARCHAR(255)
+			)`, id, datetime, datetime),
+		}
+
+		for _, stmt := range statements {
+			if err := db.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+	Down: func(db *gorm.DB, dialect dialects.Dialect) error {
+		tables := []string{
+			"db_users", "db_user_groups", "db_user_scopes", "db_credentials",
+			"db_flights", "db_incidents", "db_takeoff_types", "db_flight_types",
+			"db_wings", "db_wing_score_details", "db_start_sites", "db_clubs",
+			"db_locations", "db_coordinates", "db_file_references", "db_country_parts",
+		}
+
+		for _, table := range tables {
+			if err := db.Exec("DROP TABLE IF EXISTS " + table).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
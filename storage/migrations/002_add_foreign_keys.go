@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/klyngen/flightlogger/storage/dialects"
+)
+
+// migration002AddForeignKeys makes explicit what the old MigrateDatabase did
+// with three AddForeignKey calls whose errors were silently overwritten by
+// the next call in the chain. A no-op on dialects that don't support foreign
+// keys (SQLite), rather than failing the whole migration run.
+var migration002AddForeignKeys = Migration{
+	ID:          2,
+	Description: "add foreign keys for credentials and location references",
+	Up: func(db *gorm.DB, dialect dialects.Dialect) error {
+		if !dialect.SupportsForeignKeys() {
+			return nil
+		}
+
+		statements := []string{
+			`ALTER TABLE db_credentials ADD CONSTRAINT fk_credentials_user
+				FOREIGN KEY (user_id) REFERENCES db_users(id) ON DELETE CASCADE ON UPDATE CASCADE`,
+			`ALTER TABLE db_locations ADD CONSTRAINT fk_locations_countrypart
+				FOREIGN KEY (countrypart_referer) REFERENCES db_country_parts(id) ON DELETE SET NULL ON UPDATE SET NULL`,
+			`ALTER TABLE db_locations ADD CONSTRAINT fk_locations_coordinates
+				FOREIGN KEY (coordinates_referer) REFERENCES db_coordinates(id) ON DELETE SET NULL ON UPDATE SET NULL`,
+		}
+
+		for _, stmt := range statements {
+			if err := db.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+	Down: func(db *gorm.DB, dialect dialects.Dialect) error {
+		if !dialect.SupportsForeignKeys() {
+			return nil
+		}
+
+		// MySQL uses "DROP FOREIGN KEY", Postgres (and standard SQL) uses "DROP CONSTRAINT"
+		dropClause := "DROP CONSTRAINT"
+		if dialect.Name() == "mysql" {
+			dropClause = "DROP FOREIGN KEY"
+		}
+
+		statements := []string{
+			"ALTER TABLE db_locations " + dropClause + " fk_locations_coordinates",
+			"ALTER TABLE db_locations " + dropClause + " fk_locations_countrypart",
+			"ALTER TABLE db_credentials " + dropClause + " fk_credentials_user",
+		}
+
+		for _, stmt := range statements {
+			if err := db.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/klyngen/flightlogger/storage/dialects"
+)
+
+// migration003Waypoints adds the waypoints table. CreateWayPoint and friends
+// on OrmDatabase still panic("not implemented"), but the schema they will
+// need is introduced here so later migrations can add foreign keys against it.
+var migration003Waypoints = Migration{
+	ID:          3,
+	Description: "create the waypoints table",
+	Up: func(db *gorm.DB, dialect dialects.Dialect) error {
+		return db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_waypoints (
+			%s,
+			created_at %s,
+			name VARCHAR(255),
+			location_referer INTEGER
+		)`, idColumn(dialect), datetimeColumn(dialect))).Error
+	},
+	Down: func(db *gorm.DB, dialect dialects.Dialect) error {
+		return db.Exec("DROP TABLE IF EXISTS db_waypoints").Error
+	},
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/klyngen/flightlogger/storage/dialects"
+)
+
+// migration004AuditLog creates the table backing DbAuditLog.
+var migration004AuditLog = Migration{
+	ID:          4,
+	Description: "create the audit log table",
+	Up: func(db *gorm.DB, dialect dialects.Dialect) error {
+		return db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS db_audit_logs (
+			%s,
+			created_at %s,
+			actor_id INTEGER,
+			entity_type VARCHAR(255),
+			entity_id INTEGER,
+			operation VARCHAR(32),
+			diff TEXT
+		)`, idColumn(dialect), datetimeColumn(dialect))).Error
+	},
+	Down: func(db *gorm.DB, dialect dialects.Dialect) error {
+		return db.Exec("DROP TABLE IF EXISTS db_audit_logs").Error
+	},
+}
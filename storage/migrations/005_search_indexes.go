@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/klyngen/flightlogger/storage/dialects"
+)
+
+// migration005SearchIndexes adds the indexes SearchLocations and
+// FindLocationsNear (and their start site equivalents) rely on: a fulltext
+// index so MySQL can do MATCH ... AGAINST, and a composite index on
+// (lattitude, longitude). The haversine queries do not filter on a bounding
+// box, so they still compute the distance for every joined row.
+var migration005SearchIndexes = Migration{
+	ID:          5,
+	Description: "add fulltext and lat/lon indexes for location search",
+	Up: func(db *gorm.DB, dialect dialects.Dialect) error {
+		if dialect.Name() == "mysql" {
+			if err := db.Exec("ALTER TABLE db_locations ADD FULLTEXT INDEX idx_locations_search (name)").Error; err != nil {
+				return err
+			}
+		}
+
+		return db.Exec("CREATE INDEX idx_coordinates_lat_lon ON db_coordinates (lattitude, longitude)").Error
+	},
+	Down: func(db *gorm.DB, dialect dialects.Dialect) error {
+		if dialect.Name() == "mysql" {
+			if err := db.Exec("ALTER TABLE db_locations DROP INDEX idx_locations_search").Error; err != nil {
+				return err
+			}
+		}
+
+		return db.Exec(dropIndex(dialect, "db_coordinates", "idx_coordinates_lat_lon")).Error
+	},
+}
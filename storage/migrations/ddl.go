@@ -0,0 +1,45 @@
+package migrations
+
+import "github.com/klyngen/flightlogger/storage/dialects"
+
+// idColumn returns the dialect-specific definition for an auto-incrementing
+// primary key column named "id".
+func idColumn(dialect dialects.Dialect) string {
+	switch dialect.Name() {
+	case "postgres":
+		return "id SERIAL PRIMARY KEY"
+	case "sqlite3":
+		return "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	default: // mysql
+		return "id INTEGER PRIMARY KEY AUTO_INCREMENT"
+	}
+}
+
+// datetimeColumn returns the dialect-specific timestamp column type.
+func datetimeColumn(dialect dialects.Dialect) string {
+	if dialect.Name() == "postgres" {
+		return "TIMESTAMP"
+	}
+	return "DATETIME"
+}
+
+// binaryColumn returns the dialect-specific variable-length binary column type.
+func binaryColumn(dialect dialects.Dialect) string {
+	switch dialect.Name() {
+	case "postgres":
+		return "BYTEA"
+	case "sqlite3":
+		return "BLOB"
+	default: // mysql
+		return "VARBINARY(255)"
+	}
+}
+
+// dropIndex returns the dialect-specific statement to drop a plain (non-fulltext)
+// index. MySQL requires the table name; Postgres and SQLite don't accept it.
+func dropIndex(dialect dialects.Dialect, table string, index string) string {
+	if dialect.Name() == "mysql" {
+		return "DROP INDEX " + index + " ON " + table
+	}
+	return "DROP INDEX IF EXISTS " + index
+}
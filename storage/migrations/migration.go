@@ -0,0 +1,169 @@
+// Package migrations replaces the old hand-ordered AutoMigrate chain with a
+// numbered, rollbackable runner modeled after sql-migrate. Each migration is
+// a small, self-contained step expressed as raw SQL so that its behaviour
+// stays fixed once it has shipped, even as the application's own models
+// continue to evolve in the storage package.
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/klyngen/flightlogger/storage/dialects"
+	"github.com/pkg/errors"
+)
+
+// Migration - a single reversible schema change, applied in ascending ID order.
+type Migration struct {
+	ID          int
+	Description string
+	Up          func(db *gorm.DB, dialect dialects.Dialect) error
+	Down        func(db *gorm.DB, dialect dialects.Dialect) error
+}
+
+// dbSchemaMigration tracks which migrations have already been applied
+type dbSchemaMigration struct {
+	ID int `gorm:"primary_key"`
+}
+
+// TableName - stores applied migrations in the schema_migrations table
+func (dbSchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// All - the ordered set of migrations known to this binary. New migrations
+// must be appended here with a strictly increasing ID; never renumber or
+// remove an entry that has already shipped.
+var All = []Migration{
+	migration001InitialSchema,
+	migration002AddForeignKeys,
+	migration003Waypoints,
+	migration004AuditLog,
+	migration005SearchIndexes,
+}
+
+// AppliedStatus describes whether a single known migration has run yet
+type AppliedStatus struct {
+	Migration
+	Applied bool
+}
+
+func ensureSchemaMigrationsTable(db *gorm.DB) error {
+	return errors.Wrap(db.AutoMigrate(&dbSchemaMigration{}).Error, "Unable to create schema_migrations table")
+}
+
+func appliedIDs(db *gorm.DB) (map[int]bool, error) {
+	var rows []dbSchemaMigration
+
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, errors.Wrap(err, "Unable to read schema_migrations")
+	}
+
+	applied := make(map[int]bool, len(rows))
+	for _, row := range rows {
+		applied[row.ID] = true
+	}
+
+	return applied, nil
+}
+
+// Status - reports which of the known migrations have been applied to db and
+// which are still pending.
+func Status(db *gorm.DB) ([]AppliedStatus, error) {
+	if err := ensureSchemaMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedIDs(db)
+
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]AppliedStatus, len(All))
+
+	for i, m := range All {
+		status[i] = AppliedStatus{Migration: m, Applied: applied[m.ID]}
+	}
+
+	return status, nil
+}
+
+// MigrateUp - applies every pending migration up to and including target.
+// Pass 0 to apply everything that is currently pending. Fails fast if the
+// database already has migrations applied that this binary does not know
+// about, since that means the binary is older than the database.
+func MigrateUp(db *gorm.DB, dialect dialects.Dialect, target int) error {
+	if err := ensureSchemaMigrationsTable(db); err != nil {
+		return err
+	}
+
+	applied, err := appliedIDs(db)
+
+	if err != nil {
+		return err
+	}
+
+	highestKnown := 0
+	for _, m := range All {
+		if m.ID > highestKnown {
+			highestKnown = m.ID
+		}
+	}
+
+	for id := range applied {
+		if id > highestKnown {
+			return errors.Errorf("database has migration %d applied, but this binary only knows about up to %d - refusing to proceed", id, highestKnown)
+		}
+	}
+
+	for _, m := range All {
+		if target != 0 && m.ID > target {
+			break
+		}
+
+		if applied[m.ID] {
+			continue
+		}
+
+		if err := m.Up(db, dialect); err != nil {
+			return errors.Wrapf(err, "Migration %d (%s) failed", m.ID, m.Description)
+		}
+
+		if err := db.Create(&dbSchemaMigration{ID: m.ID}).Error; err != nil {
+			return errors.Wrapf(err, "Unable to record migration %d as applied", m.ID)
+		}
+	}
+
+	return nil
+}
+
+// MigrateDown - rolls back every applied migration with an ID greater than
+// target, most recent first.
+func MigrateDown(db *gorm.DB, dialect dialects.Dialect, target int) error {
+	if err := ensureSchemaMigrationsTable(db); err != nil {
+		return err
+	}
+
+	applied, err := appliedIDs(db)
+
+	if err != nil {
+		return err
+	}
+
+	for i := len(All) - 1; i >= 0; i-- {
+		m := All[i]
+
+		if m.ID <= target || !applied[m.ID] {
+			continue
+		}
+
+		if err := m.Down(db, dialect); err != nil {
+			return errors.Wrapf(err, "Rollback of migration %d (%s) failed", m.ID, m.Description)
+		}
+
+		if err := db.Delete(&dbSchemaMigration{}, "id = ?", m.ID).Error; err != nil {
+			return errors.Wrapf(err, "Unable to unmark migration %d as applied", m.ID)
+		}
+	}
+
+	return nil
+}
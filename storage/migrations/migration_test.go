@@ -0,0 +1,93 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite3"
+	"github.com/klyngen/flightlogger/storage/dialects"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("unable to open in-memory sqlite3 connection: %v", err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestMigrateUpAppliesEveryMigration(t *testing.T) {
+	db := openTestDB(t)
+	dialect, err := dialects.For("sqlite3")
+	if err != nil {
+		t.Fatalf("unable to resolve sqlite3 dialect: %v", err)
+	}
+
+	if err := MigrateUp(db, dialect, 0); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+
+	status, err := Status(db)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+
+	for _, s := range status {
+		if !s.Applied {
+			t.Fatalf("migration %d (%s) was not applied", s.ID, s.Description)
+		}
+	}
+}
+
+func TestMigrateDownRollsBackAppliedMigrations(t *testing.T) {
+	db := openTestDB(t)
+	dialect, err := dialects.For("sqlite3")
+	if err != nil {
+		t.Fatalf("unable to resolve sqlite3 dialect: %v", err)
+	}
+
+	if err := MigrateUp(db, dialect, 0); err != nil {
+		t.Fatalf("MigrateUp failed: %v", err)
+	}
+
+	if err := MigrateDown(db, dialect, 0); err != nil {
+		t.Fatalf("MigrateDown failed: %v", err)
+	}
+
+	status, err := Status(db)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+
+	for _, s := range status {
+		if s.Applied {
+			t.Fatalf("migration %d (%s) is still marked applied after rolling back to 0", s.ID, s.Description)
+		}
+	}
+}
+
+func TestMigrateUpFailsFastOnUnknownAppliedMigration(t *testing.T) {
+	db := openTestDB(t)
+	dialect, err := dialects.For("sqlite3")
+	if err != nil {
+		t.Fatalf("unable to resolve sqlite3 dialect: %v", err)
+	}
+
+	if err := ensureSchemaMigrationsTable(db); err != nil {
+		t.Fatalf("unable to create schema_migrations table: %v", err)
+	}
+
+	// Simulate a database that was migrated by a newer binary than this one.
+	if err := db.Create(&dbSchemaMigration{ID: 999}).Error; err != nil {
+		t.Fatalf("unable to seed an unknown applied migration: %v", err)
+	}
+
+	if err := MigrateUp(db, dialect, 0); err == nil {
+		t.Fatal("expected MigrateUp to refuse to run against a database with an unknown migration applied")
+	}
+}
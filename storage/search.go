@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/klyngen/flightlogger/common"
+	"github.com/klyngen/flightlogger/storage/errs"
+	"github.com/pkg/errors"
+)
+
+// earthRadiusKm is the mean radius used by the haversine distance queries below
+const earthRadiusKm = 6371
+
+// haversineDistanceSQL is the haversine great-circle distance formula shared
+// by FindLocationsNear and FindStartSitesNear, each taking (earthRadiusKm,
+// lat, lon, lat) as its placeholder args in that order. It's repeated in both
+// the Select and the Having clause of those queries rather than referencing
+// the "distance" alias from Having - Postgres (unlike MySQL) doesn't allow a
+// SELECT-list alias to be used in HAVING, only in ORDER BY/GROUP BY.
+const haversineDistanceSQL = "(? * acos(cos(radians(?)) * cos(radians(db_coordinates.lattitude)) * cos(radians(db_coordinates.longitude) - radians(?)) + sin(radians(?)) * sin(radians(db_coordinates.lattitude))))"
+
+// SearchQuery - a free-text location search. Term is matched against name,
+// area_name and country_part.
+type SearchQuery struct {
+	Term string
+}
+
+// SearchLocations - free-text search over locations. On MySQL this uses
+// MATCH ... AGAINST against the fulltext index added by migration 005; on
+// other dialects (and as a fallback if the fulltext query itself fails, e.g.
+// the index hasn't been created yet) it falls back to a prefix LIKE, same as
+// the old LocationSearchByName.
+func (d *OrmDatabase) SearchLocations(q SearchQuery) ([]common.Location, error) {
+	term := strings.TrimSpace(q.Term)
+
+	if term == "" {
+		return nil, errors.New("SearchLocations requires a non-empty term")
+	}
+
+	var locations []DbLocation
+	query := d.db.Joins("LEFT JOIN db_country_parts ON db_country_parts.id = db_locations.countrypart_referer")
+
+	var err error
+	if d.dialect != nil && d.dialect.Name() == "mysql" {
+		err = query.Where(
+			"MATCH(db_locations.name) AGAINST (? IN NATURAL LANGUAGE MODE) OR db_country_parts.area_name LIKE ? OR db_country_parts.country_part LIKE ?",
+			term, term+"%", term+"%",
+		).Find(&locations).Error
+	}
+
+	if err != nil || d.dialect == nil || d.dialect.Name() != "mysql" {
+		err = query.Where(
+			"db_locations.name LIKE ? OR db_country_parts.area_name LIKE ? OR db_country_parts.country_part LIKE ?",
+			term+"%", term+"%", term+"%",
+		).Find(&locations).Error
+	}
+
+	if err != nil {
+		return nil, errs.Classify(err)
+	}
+
+	return demapLocations(locations), nil
+}
+
+// haversineKm returns the great-circle distance in km between two
+// lat/lon points, using the same formula and earth radius as the SQL
+// queries below.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	a := math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Cos(rad(lon2)-rad(lon1)) + math.Sin(rad(lat1))*math.Sin(rad(lat2))
+	// a can drift a hair outside [-1, 1] due to floating point rounding,
+	// which makes acos return NaN.
+	if a > 1 {
+		a = 1
+	} else if a < -1 {
+		a = -1
+	}
+
+	return earthRadiusKm * math.Acos(a)
+}
+
+// FindLocationsNear - returns locations within radiusKm of (lat, lon),
+// nearest first, using the haversine great-circle distance formula. The
+// query has no bounding-box prefilter: the distance is computed for every
+// location that has coordinates, and only then compared against radiusKm.
+//
+// sqlite3 (used in tests, see CreateConnectionWithDriver) isn't guaranteed to
+// have acos/cos/sin/radians without the optional math-functions extension, so
+// on that dialect the distance is computed in Go instead, same as
+// SearchLocations falls back off MySQL's MATCH ... AGAINST.
+func (d *OrmDatabase) FindLocationsNear(lat float64, lon float64, radiusKm float64) ([]common.Location, error) {
+	if d.dialect != nil && d.dialect.Name() == "sqlite3" {
+		var rows []struct {
+			DbLocation
+			Lattitude float64
+			Longitude float64
+		}
+
+		err := d.db.Table("db_locations").
+			Joins("JOIN db_coordinates ON db_coordinates.id = db_locations.coordinates_referer").
+			Select("db_locations.*, db_coordinates.lattitude, db_coordinates.longitude").
+			Find(&rows).Error
+
+		if err != nil {
+			return nil, errs.Classify(err)
+		}
+
+		sort.Slice(rows, func(i, j int) bool {
+			return haversineKm(lat, lon, rows[i].Lattitude, rows[i].Longitude) < haversineKm(lat, lon, rows[j].Lattitude, rows[j].Longitude)
+		})
+
+		locations := make([]DbLocation, 0, len(rows))
+		for _, row := range rows {
+			if haversineKm(lat, lon, row.Lattitude, row.Longitude) < radiusKm {
+				locations = append(locations, row.DbLocation)
+			}
+		}
+
+		return demapLocations(locations), nil
+	}
+
+	var locations []DbLocation
+
+	err := d.db.Table("db_locations").
+		Joins("JOIN db_coordinates ON db_coordinates.id = db_locations.coordinates_referer").
+		Select("db_locations.*, "+haversineDistanceSQL+" AS distance", earthRadiusKm, lat, lon, lat).
+		Having(haversineDistanceSQL+" < ?", earthRadiusKm, lat, lon, lat, radiusKm).
+		Order("distance").
+		Find(&locations).Error
+
+	if err != nil {
+		return nil, errs.Classify(err)
+	}
+
+	return demapLocations(locations), nil
+}
+
+// FindStartSitesNear - same as FindLocationsNear, but over start sites
+// (joined through their underlying location), so pilots can discover
+// flyable sites within e.g. 50 km of their position.
+func (d *OrmDatabase) FindStartSitesNear(lat float64, lon float64, radiusKm float64) ([]common.StartSite, error) {
+	if d.dialect != nil && d.dialect.Name() == "sqlite3" {
+		var rows []struct {
+			DbStartSite
+			Lattitude float64
+			Longitude float64
+		}
+
+		err := d.db.Table("db_start_sites").
+			Joins("JOIN db_locations ON db_locations.id = db_start_sites.location_referer").
+			Joins("JOIN db_coordinates ON db_coordinates.id = db_locations.coordinates_referer").
+			Select("db_start_sites.*, db_coordinates.lattitude, db_coordinates.longitude").
+			Find(&rows).Error
+
+		if err != nil {
+			return nil, errs.Classify(err)
+		}
+
+		sort.Slice(rows, func(i, j int) bool {
+			return haversineKm(lat, lon, rows[i].Lattitude, rows[i].Longitude) < haversineKm(lat, lon, rows[j].Lattitude, rows[j].Longitude)
+		})
+
+		sites := make([]DbStartSite, 0, len(rows))
+		for _, row := range rows {
+			if haversineKm(lat, lon, row.Lattitude, row.Longitude) < radiusKm {
+				sites = append(sites, row.DbStartSite)
+			}
+		}
+
+		return demapStartSites(sites), nil
+	}
+
+	var sites []DbStartSite
+
+	err := d.db.Table("db_start_sites").
+		Joins("JOIN db_locations ON db_locations.id = db_start_sites.location_referer").
+		Joins("JOIN db_coordinates ON db_coordinates.id = db_locations.coordinates_referer").
+		Select("db_start_sites.*, "+haversineDistanceSQL+" AS distance", earthRadiusKm, lat, lon, lat).
+		Having(haversineDistanceSQL+" < ?", earthRadiusKm, lat, lon, lat, radiusKm).
+		Order("distance").
+		Find(&sites).Error
+
+	if err != nil {
+		return nil, errs.Classify(err)
+	}
+
+	return demapStartSites(sites), nil
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/klyngen/flightlogger/storage/dialects"
+	"github.com/klyngen/flightlogger/storage/migrations"
+)
+
+// sqlCapture is a minimal gorm logger that records every statement gorm
+// prints in LogMode(true), so a test can inspect the SQL actually sent to
+// the driver instead of only the rows that come back.
+type sqlCapture struct {
+	statements []string
+}
+
+func (c *sqlCapture) Print(values ...interface{}) {
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			c.statements = append(c.statements, s)
+		}
+	}
+}
+
+// TestFindLocationsNearHavingRepeatsDistanceExpression guards the non-sqlite3
+// SQL path of FindLocationsNear: Postgres (unlike MySQL) doesn't allow a
+// SELECT-list alias to be referenced from HAVING, so the HAVING clause must
+// repeat the full haversine expression rather than filtering on "distance".
+// The underlying connection here is still sqlite3 (there's no Postgres
+// available to test against), but the dialect is swapped out so the
+// mysql/postgres query-building branch runs; we only assert on the SQL gorm
+// generates, not on query results.
+func TestFindLocationsNearHavingRepeatsDistanceExpression(t *testing.T) {
+	db := newTestOrmDatabase(t)
+
+	if err := migrations.MigrateUp(db.db, db.dialect, 0); err != nil {
+		t.Fatalf("unable to run migrations: %v", err)
+	}
+
+	postgres, err := dialects.For("postgres")
+	if err != nil {
+		t.Fatalf("unable to resolve postgres dialect: %v", err)
+	}
+	db.dialect = postgres
+
+	capture := &sqlCapture{}
+	db.db.SetLogger(capture)
+	db.db.LogMode(true)
+
+	// The query fails against the sqlite3 table underneath (no Postgres to
+	// run it against), which is fine - we only care about the SQL gorm built.
+	_, _ = db.FindLocationsNear(59.91, 10.75, 50)
+
+	captured := strings.Join(capture.statements, " | ")
+
+	if !strings.Contains(captured, "HAVING") {
+		t.Fatalf("expected a HAVING clause in the generated SQL, got: %s", captured)
+	}
+
+	if strings.Contains(captured, "HAVING distance") {
+		t.Fatalf("HAVING still references the bare \"distance\" select-list alias, which Postgres rejects: %s", captured)
+	}
+}
+
+// TestFindLocationsNearOnSqlite3 guards against FindLocationsNear relying on
+// acos/cos/sin/radians, which the default sqlite3 driver build doesn't ship -
+// this dialect is exactly the one CreateConnectionWithDriver documents as
+// "handy for tests".
+func TestFindLocationsNearOnSqlite3(t *testing.T) {
+	db := newTestOrmDatabase(t)
+
+	if err := migrations.MigrateUp(db.db, db.dialect, 0); err != nil {
+		t.Fatalf("unable to run migrations: %v", err)
+	}
+
+	// Oslo
+	if err := db.db.Exec("INSERT INTO db_coordinates (id, lattitude, longitude) VALUES (1, 59.91, 10.75)").Error; err != nil {
+		t.Fatalf("unable to seed coordinates: %v", err)
+	}
+	if err := db.db.Exec("INSERT INTO db_locations (id, name, coordinates_referer) VALUES (1, 'Oslo', 1)").Error; err != nil {
+		t.Fatalf("unable to seed a location: %v", err)
+	}
+
+	// Tokyo - far outside any reasonable radius of Oslo
+	if err := db.db.Exec("INSERT INTO db_coordinates (id, lattitude, longitude) VALUES (2, 35.68, 139.69)").Error; err != nil {
+		t.Fatalf("unable to seed coordinates: %v", err)
+	}
+	if err := db.db.Exec("INSERT INTO db_locations (id, name, coordinates_referer) VALUES (2, 'Tokyo', 2)").Error; err != nil {
+		t.Fatalf("unable to seed a location: %v", err)
+	}
+
+	locations, err := db.FindLocationsNear(59.91, 10.75, 50)
+
+	if err != nil {
+		t.Fatalf("FindLocationsNear failed on sqlite3: %v", err)
+	}
+
+	if len(locations) != 1 {
+		t.Fatalf("expected exactly 1 location within 50km of Oslo, got %d", len(locations))
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"github.com/klyngen/flightlogger/common"
+	"github.com/klyngen/flightlogger/storage/errs"
+)
+
+// Database - the subset of OrmDatabase's behaviour that is safe to call from
+// within a WithTx callback. Kept separate from *gorm.DB so callers compose
+// atomic operations against the same methods they'd use outside a
+// transaction, rather than writing raw SQL.
+type Database interface {
+	CreateUser(actorID uint, user common.User) (common.User, error)
+	UpdateUser(actorID uint, ID uint, user common.User) (common.User, error)
+	DeleteUser(actorID uint, ID uint) error
+	CreateLocation(actorID uint, location common.Location) (common.Location, error)
+	UpdateLocation(actorID uint, ID uint, location common.Location) (common.Location, error)
+	DeleteLocation(actorID uint, ID uint) error
+}
+
+// WithTx - runs fn inside a single database transaction, committing if fn
+// returns nil and rolling back otherwise. fn is handed a Database backed by
+// the transaction, so a caller can freely compose multiple of the methods
+// above (e.g. "create user + location in one go") and
+// know that a failure partway through leaves no partial writes behind.
+//
+// If d is already running inside a transaction (i.e. it was itself handed to
+// an outer WithTx callback), fn reuses that transaction instead of calling
+// Begin() again - gorm can't open a transaction on top of an already-open
+// one, so without this a composed call like
+// "tx.CreateUser(...); tx.CreateLocation(...)" would fail.
+func (d *OrmDatabase) WithTx(fn func(tx Database) error) error {
+	if d.inTx {
+		return fn(d)
+	}
+
+	txDB := d.db.Begin()
+
+	if txDB.Error != nil {
+		return errs.Classify(txDB.Error)
+	}
+
+	tx := &OrmDatabase{db: txDB, dialect: d.dialect, inTx: true}
+
+	if err := fn(tx); err != nil {
+		txDB.Rollback()
+		return err
+	}
+
+	return errs.Classify(txDB.Commit().Error)
+}
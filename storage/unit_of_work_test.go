@@ -0,0 +1,36 @@
+package storage
+
+import "testing"
+
+func newTestOrmDatabase(t *testing.T) *OrmDatabase {
+	t.Helper()
+
+	db := &OrmDatabase{}
+
+	if err := db.CreateConnectionWithDriver("sqlite3", ":memory:"); err != nil {
+		t.Fatalf("unable to open in-memory sqlite3 connection: %v", err)
+	}
+
+	return db
+}
+
+// TestWithTxReusesOpenTransaction guards against the bug where a Database
+// handed to an outer WithTx callback would call Begin() again on its own
+// already-open transaction when composing a second WithTx call - gorm can't
+// start a transaction on top of one that's already open, so the composed
+// operation this API exists for (see unit_of_work.go) would always fail.
+func TestWithTxReusesOpenTransaction(t *testing.T) {
+	db := newTestOrmDatabase(t)
+
+	err := db.WithTx(func(tx Database) error {
+		inner := tx.(*OrmDatabase)
+
+		return inner.WithTx(func(tx2 Database) error {
+			return nil
+		})
+	})
+
+	if err != nil {
+		t.Fatalf("composed WithTx call failed: %v", err)
+	}
+}